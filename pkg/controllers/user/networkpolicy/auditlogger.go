@@ -0,0 +1,129 @@
+package networkpolicy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	knetworkingv1 "k8s.io/api/networking/v1"
+)
+
+// Audit dispositions recorded by an AuditLogger.
+const (
+	AuditDispositionCreated    = "created"
+	AuditDispositionUpdated    = "updated"
+	AuditDispositionReconciled = "reconciled"
+)
+
+// AuditPort describes a single hostPort/containerPort/protocol tuple that a
+// programmed NetworkPolicy opened up for a pod.
+type AuditPort struct {
+	Container     string `json:"container"`
+	HostPort      int32  `json:"hostPort"`
+	ContainerPort int32  `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+}
+
+// AuditEvent is emitted every time podHandler creates or updates a
+// hp-<podname> NetworkPolicy, and every time the reconciler repairs one.
+type AuditEvent struct {
+	Time        time.Time   `json:"time"`
+	Disposition string      `json:"disposition"`
+	PolicyName  string      `json:"policyName"`
+	Namespace   string      `json:"namespace"`
+	PodUID      string      `json:"podUID,omitempty"`
+	PodName     string      `json:"podName,omitempty"`
+	Node        string      `json:"node,omitempty"`
+	Ports       []AuditPort `json:"ports,omitempty"`
+	PolicyHash  string      `json:"policyHash"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// AuditLogger gives operators a trail of which host-port exposures were
+// auto-firewalled by this controller. The zero-value default is a no-op so
+// existing deployments see no behavior change.
+type AuditLogger interface {
+	LogPolicyEvent(event AuditEvent)
+}
+
+type noopAuditLogger struct{}
+
+func (noopAuditLogger) LogPolicyEvent(AuditEvent) {}
+
+// policyHash returns a short, stable hash of a NetworkPolicy's spec so an
+// audit trail can cheaply tell "same policy reconciled" apart from "policy
+// actually changed" without diffing the whole object.
+func policyHash(np *knetworkingv1.NetworkPolicy) string {
+	raw, err := json.Marshal(np.Spec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// fileAuditLogger is the file-backed AuditLogger: one JSON line per event,
+// written under dir and rotated once the active file exceeds maxBytes.
+type fileAuditLogger struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	file    *os.File
+	written int64
+}
+
+// NewFileAuditLogger returns an AuditLogger that appends newline-delimited
+// JSON events to rotated files under dir (created if it doesn't exist).
+// maxBytes of zero or less disables rotation.
+func NewFileAuditLogger(dir string, maxBytes int64) (AuditLogger, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating audit log dir %s: %v", dir, err)
+	}
+	return &fileAuditLogger{dir: dir, maxBytes: maxBytes}, nil
+}
+
+func (f *fileAuditLogger) LogPolicyEvent(event AuditEvent) {
+	event.Time = time.Now()
+	line, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("fileAuditLogger: failed to marshal audit event: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.rotateIfNeededLocked(int64(len(line))); err != nil {
+		logrus.Errorf("fileAuditLogger: failed to rotate audit log: %v", err)
+		return
+	}
+	if _, err := f.file.Write(line); err != nil {
+		logrus.Errorf("fileAuditLogger: failed to write audit event: %v", err)
+		return
+	}
+	f.written += int64(len(line))
+}
+
+func (f *fileAuditLogger) rotateIfNeededLocked(nextWrite int64) error {
+	if f.file != nil && (f.maxBytes <= 0 || f.written+nextWrite <= f.maxBytes) {
+		return nil
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	path := filepath.Join(f.dir, fmt.Sprintf("hostport-netpol-audit-%d.jsonl", time.Now().UnixNano()))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.written = 0
+	return nil
+}
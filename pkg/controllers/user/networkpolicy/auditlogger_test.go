@@ -0,0 +1,36 @@
+package networkpolicy
+
+import (
+	"testing"
+
+	knetworkingv1 "k8s.io/api/networking/v1"
+)
+
+type recordingAuditLogger struct {
+	events []AuditEvent
+}
+
+func (r *recordingAuditLogger) LogPolicyEvent(event AuditEvent) {
+	r.events = append(r.events, event)
+}
+
+func TestPolicyHashStableForEquivalentSpecs(t *testing.T) {
+	pod := podWithIPs("10.42.0.12")
+	np1 := generatePodNetworkPolicies(pod, true, false, familyScope{ok: true}, familyScope{}, familyScope{}, familyScope{}, false)[0]
+	np2 := generatePodNetworkPolicies(pod, true, false, familyScope{ok: true}, familyScope{}, familyScope{}, familyScope{}, false)[0]
+
+	if policyHash(np1) != policyHash(np2) {
+		t.Fatalf("expected equivalent policies to hash the same")
+	}
+
+	np2.Spec.Ingress[0].Ports = append(np2.Spec.Ingress[0].Ports, knetworkingv1.NetworkPolicyPort{})
+	if policyHash(np1) == policyHash(np2) {
+		t.Fatalf("expected differing policies to hash differently")
+	}
+}
+
+func TestNoopAuditLoggerDoesNothing(t *testing.T) {
+	// exercised for the side effect of not panicking when audit is unset.
+	var logger AuditLogger = noopAuditLogger{}
+	logger.LogPolicyEvent(AuditEvent{})
+}
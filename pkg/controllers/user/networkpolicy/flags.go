@@ -0,0 +1,32 @@
+package networkpolicy
+
+import (
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// HostPortNetpolResyncFlag is the flag name callers (rancher's server CLI)
+// register to override DefaultHostPortNetpolResync.
+const HostPortNetpolResyncFlag = "hostport-netpol-resync"
+
+// NewHostPortNetpolResyncFlag returns the cli.Flag for --hostport-netpol-resync,
+// so it can be added alongside this controller's other server flags.
+func NewHostPortNetpolResyncFlag() cli.Flag {
+	return cli.DurationFlag{
+		Name:   HostPortNetpolResyncFlag,
+		Usage:  "Interval between reconciliation passes over hp-<podname> NetworkPolicies",
+		Value:  DefaultHostPortNetpolResync,
+		EnvVar: "RANCHER_HOSTPORT_NETPOL_RESYNC",
+	}
+}
+
+// ResyncIntervalFromFlag reads the resync interval from ctx, falling back to
+// DefaultHostPortNetpolResync when unset or non-positive.
+func ResyncIntervalFromFlag(ctx *cli.Context) time.Duration {
+	interval := ctx.Duration(HostPortNetpolResyncFlag)
+	if interval <= 0 {
+		return DefaultHostPortNetpolResync
+	}
+	return interval
+}
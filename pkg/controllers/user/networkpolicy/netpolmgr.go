@@ -0,0 +1,122 @@
+package networkpolicy
+
+import (
+	"reflect"
+	"time"
+
+	v1 "github.com/rancher/types/apis/core/v1"
+	networkingv1 "github.com/rancher/types/apis/networking.k8s.io/v1"
+	"github.com/sirupsen/logrus"
+	knetworkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// netpolMgr creates/updates the NetworkPolicy objects programmed by this
+// controller and keeps them converged with the pods/namespaces they were
+// generated from.
+type netpolMgr struct {
+	npLister networkingv1.NetworkPolicyLister
+	npClient networkingv1.NetworkPolicyInterface
+	audit    AuditLogger
+}
+
+// NetpolMgrOption configures a netpolMgr built by NewNetpolMgr.
+type NetpolMgrOption func(*netpolMgr)
+
+// WithAuditLogger attaches logger so every NetworkPolicy this netpolMgr
+// creates or updates (and every port repair by the reconciler) is recorded
+// through it, instead of the no-op default. The reconciler's deletions of
+// orphaned policies are not recorded.
+func WithAuditLogger(logger AuditLogger) NetpolMgrOption {
+	return func(npmgr *netpolMgr) {
+		npmgr.audit = logger
+	}
+}
+
+// NewNetpolMgr builds a netpolMgr that creates/updates/converges
+// hp-<podname> NetworkPolicies through npClient. By default it audits
+// nothing; pass WithAuditLogger to attach a real AuditLogger such as the
+// one returned by NewFileAuditLogger.
+func NewNetpolMgr(npLister networkingv1.NetworkPolicyLister, npClient networkingv1.NetworkPolicyInterface, opts ...NetpolMgrOption) *netpolMgr {
+	npmgr := &netpolMgr{
+		npLister: npLister,
+		npClient: npClient,
+		audit:    noopAuditLogger{},
+	}
+	for _, opt := range opts {
+		opt(npmgr)
+	}
+	return npmgr
+}
+
+// StartReconciler launches a background loop that periodically converges
+// every hp-<podname> NetworkPolicy against its owning pod, deleting orphans
+// and repairing drifted ports. It returns immediately; stopc shuts the loop
+// down.
+func (npmgr *netpolMgr) StartReconciler(podLister v1.PodLister, interval time.Duration, stopc <-chan struct{}) {
+	r := newReconciler(npmgr, npmgr.npClient, podLister, interval)
+	go r.run(stopc)
+}
+
+// podAuditContext carries the pod/container detail behind a programmed
+// policy so the AuditLogger can explain *why* a hostPort was firewalled,
+// not just which NetworkPolicy object changed.
+type podAuditContext struct {
+	PodUID    string
+	PodName   string
+	Namespace string
+	Node      string
+	Ports     []AuditPort
+}
+
+// program creates np if it doesn't exist yet, or updates the existing
+// policy in place if its spec has drifted. podCtx may be nil for callers
+// that don't have pod detail on hand.
+func (npmgr *netpolMgr) program(np *knetworkingv1.NetworkPolicy, podCtx *podAuditContext) error {
+	existing, err := npmgr.npLister.Get(np.Namespace, np.Name)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+
+	if existing == nil {
+		logrus.Debugf("netpolMgr: program: creating networkPolicy=%+v", *np)
+		_, err := npmgr.npClient.Create(np)
+		npmgr.auditProgram(AuditDispositionCreated, np, podCtx, err)
+		return err
+	}
+
+	if reflect.DeepEqual(existing.Spec, np.Spec) {
+		return nil
+	}
+
+	toUpdate := existing.DeepCopy()
+	toUpdate.Spec = np.Spec
+	logrus.Debugf("netpolMgr: program: updating networkPolicy=%+v", *toUpdate)
+	_, err = npmgr.npClient.Update(toUpdate)
+	npmgr.auditProgram(AuditDispositionUpdated, toUpdate, podCtx, err)
+	return err
+}
+
+// auditProgram records a create/update/reconcile disposition for np,
+// no-op'ing cleanly when no AuditLogger has been configured.
+func (npmgr *netpolMgr) auditProgram(disposition string, np *knetworkingv1.NetworkPolicy, podCtx *podAuditContext, err error) {
+	if npmgr.audit == nil {
+		return
+	}
+	event := AuditEvent{
+		Disposition: disposition,
+		PolicyName:  np.Name,
+		Namespace:   np.Namespace,
+		PolicyHash:  policyHash(np),
+	}
+	if podCtx != nil {
+		event.PodUID = podCtx.PodUID
+		event.PodName = podCtx.PodName
+		event.Node = podCtx.Node
+		event.Ports = podCtx.Ports
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	npmgr.audit.LogPolicyEvent(event)
+}
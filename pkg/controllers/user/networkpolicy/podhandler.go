@@ -1,9 +1,11 @@
 package networkpolicy
 
 import (
+	"net"
 	"sort"
 
 	"github.com/rancher/types/apis/core/v1"
+	managementv3 "github.com/rancher/types/apis/management.cattle.io/v3"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	knetworkingv1 "k8s.io/api/networking/v1"
@@ -15,11 +17,20 @@ const (
 	// PodNameFieldLabel is used to specify the podName for pods
 	// with hostPort specified
 	PodNameFieldLabel = "field.cattle.io/podName"
+
+	ipv4Any = "0.0.0.0/0"
+	ipv6Any = "::/0"
+
+	ipv4Suffix = "-v4"
+	ipv6Suffix = "-v6"
 )
 
 type podHandler struct {
 	npmgr            *netpolMgr
 	pods             v1.PodInterface
+	nsLister         v1.NamespaceLister
+	nodeLister       v1.NodeLister
+	clusterLister    managementv3.ClusterLister
 	clusterNamespace string
 }
 
@@ -32,7 +43,7 @@ func (ph *podHandler) Sync(key string, pod *corev1.Pod) error {
 	if err := ph.addLabelIfHostPortsPresent(pod); err != nil {
 		return err
 	}
-	return ph.npmgr.hostPortsUpdateHandler(pod, ph.clusterNamespace)
+	return ph.hostPortsUpdateHandler(pod)
 }
 
 // k8s native network policy can select pods only using labels,
@@ -70,39 +81,274 @@ Loop:
 }
 
 func (ph *podHandler) hostPortsUpdateHandler(pod *corev1.Pod) error {
-	np := generatePodNetworkPolicy(pod)
+	ports, hasHostPorts := hostPorts(pod)
+	if !hasHostPorts {
+		return nil
+	}
+
+	ipv4, ipv6 := podIPFamilies(pod)
+	if !ipv4 && !ipv6 {
+		// Status isn't populated yet (e.g. pod still pending); fall back to
+		// the cluster's own address family so we still program a policy.
+		ipv4, ipv6 = ph.clusterIPFamilies()
+	}
+
+	v4Except, v6Except := ph.clusterCIDRExceptions()
+	mode := ph.hostPortPolicyMode(pod.Namespace)
+	v4Peers, v6Peers, err := ph.resolvePeers(pod, mode, ipv4, ipv6, v4Except, v6Except)
+	if err != nil {
+		return err
+	}
+	v4EgressPeers, v6EgressPeers, egressEnabled := ph.resolveEgressPeers(mode, v4Peers, v6Peers, v4Except, v6Except)
+
+	restrictive := mode != HostPortPolicyAllowAll
+	v4Ingress := resolveFamilyScope(ipv4, restrictive, v4Peers, pod, "IPv4 ingress")
+	v6Ingress := resolveFamilyScope(ipv6, restrictive, v6Peers, pod, "IPv6 ingress")
+	v4Egress := resolveFamilyScope(ipv4, egressEnabled, v4EgressPeers, pod, "IPv4 egress")
+	v6Egress := resolveFamilyScope(ipv6, egressEnabled, v6EgressPeers, pod, "IPv6 egress")
+
+	for _, np := range generatePodNetworkPolicies(pod, ipv4, ipv6, v4Ingress, v6Ingress, v4Egress, v6Egress, egressEnabled) {
+		if len(np.Spec.Ingress) > 0 {
+			np.Spec.Ingress[0].Ports = append(np.Spec.Ingress[0].Ports, ports...)
+
+			// sort ports so it always appears in a certain order
+			sort.Slice(np.Spec.Ingress[0].Ports, func(i, j int) bool {
+				return portToString(np.Spec.Ingress[0].Ports[i]) < portToString(np.Spec.Ingress[0].Ports[j])
+			})
+		}
+
+		logrus.Debugf("netpolMgr: hostPortsUpdateHandler: pod=%+v has host ports, hence programming np=%+v", *pod, *np)
+		if err := ph.npmgr.program(np, auditContextFromPod(pod)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostPorts returns the NetworkPolicyPorts for every hostPort exposed by pod.
+func hostPorts(pod *corev1.Pod) ([]knetworkingv1.NetworkPolicyPort, bool) {
+	var ports []knetworkingv1.NetworkPolicyPort
 	hasHostPorts := false
 	for _, c := range pod.Spec.Containers {
 		for _, port := range c.Ports {
 			if port.HostPort != 0 {
 				hp := intstr.FromInt(int(port.ContainerPort))
 				proto := corev1.Protocol(port.Protocol)
-				p := knetworkingv1.NetworkPolicyPort{
+				ports = append(ports, knetworkingv1.NetworkPolicyPort{
 					Protocol: &proto,
 					Port:     &hp,
-				}
-				np.Spec.Ingress[0].Ports = append(np.Spec.Ingress[0].Ports, p)
+				})
 				hasHostPorts = true
 			}
 		}
 	}
-	if !hasHostPorts {
-		return nil
+	return ports, hasHostPorts
+}
+
+// auditContextFromPod gathers the pod/container detail an AuditLogger needs
+// to explain why a hp-<podname> NetworkPolicy was programmed.
+func auditContextFromPod(pod *corev1.Pod) *podAuditContext {
+	ctx := &podAuditContext{
+		PodUID:    string(pod.UID),
+		PodName:   pod.Name,
+		Namespace: pod.Namespace,
+		Node:      pod.Spec.NodeName,
 	}
+	for _, c := range pod.Spec.Containers {
+		for _, port := range c.Ports {
+			if port.HostPort == 0 {
+				continue
+			}
+			ctx.Ports = append(ctx.Ports, AuditPort{
+				Container:     c.Name,
+				HostPort:      port.HostPort,
+				ContainerPort: port.ContainerPort,
+				Protocol:      string(port.Protocol),
+			})
+		}
+	}
+	return ctx
+}
 
-	// sort ports so it always appears in a certain order
-	sort.Slice(np.Spec.Ingress[0].Ports, func(i, j int) bool {
-		return portToString(np.Spec.Ingress[0].Ports[i]) < portToString(np.Spec.Ingress[0].Ports[j])
-	})
+// podIPFamilies reports which IP families are present in pod.Status.PodIPs,
+// falling back to the legacy singular PodIP field for older API servers.
+func podIPFamilies(pod *corev1.Pod) (ipv4, ipv6 bool) {
+	ips := pod.Status.PodIPs
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = []corev1.PodIP{{IP: pod.Status.PodIP}}
+	}
+	for _, podIP := range ips {
+		ip := net.ParseIP(podIP.IP)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			ipv4 = true
+		} else {
+			ipv6 = true
+		}
+	}
+	return
+}
 
-	logrus.Debugf("netpolMgr: hostPortsUpdateHandler: pod=%+v has host ports, hence programming np=%+v", *pod, *np)
-	return ph.npmgr.program(np)
+// clusterIPFamilies is a best-effort fallback used when a pod's own IPs
+// aren't known yet: it reports the address families the cluster is
+// configured for so we still program something sane for the pod's eventual
+// stack instead of silently skipping it.
+func (ph *podHandler) clusterIPFamilies() (ipv4, ipv6 bool) {
+	v4, v6 := ph.clusterCIDRExceptions()
+	return len(v4) > 0, len(v6) > 0
 }
 
-func generatePodNetworkPolicy(pod *corev1.Pod) *knetworkingv1.NetworkPolicy {
+// clusterCIDRExceptions returns the cluster's pod/service CIDRs per IP
+// family, used as `except` entries so the allow-all IPBlock peer doesn't
+// also open up cluster-internal ranges unnecessarily.
+func (ph *podHandler) clusterCIDRExceptions() (v4Except, v6Except []string) {
+	if ph.clusterLister == nil {
+		return nil, nil
+	}
+	cluster, err := ph.clusterLister.Get("", ph.clusterNamespace)
+	if err != nil || cluster == nil {
+		logrus.Debugf("podHandler: clusterCIDRExceptions: unable to get cluster %v: %v", ph.clusterNamespace, err)
+		return nil, nil
+	}
+	rke := cluster.Spec.RancherKubernetesEngineConfig
+	if rke == nil {
+		return nil, nil
+	}
+	cidrs := []string{rke.Services.KubeController.ClusterCIDR, rke.Services.KubeAPI.ServiceClusterIPRange}
+	for _, cidr := range cidrs {
+		if cidr == "" {
+			continue
+		}
+		for _, c := range splitCIDRList(cidr) {
+			if isIPv6CIDR(c) {
+				v6Except = append(v6Except, c)
+			} else {
+				v4Except = append(v4Except, c)
+			}
+		}
+	}
+	return v4Except, v6Except
+}
+
+// splitCIDRList splits a comma-separated dual-stack CIDR field (as used by
+// RKE's ClusterCIDR/ServiceClusterIPRange) into its individual entries.
+func splitCIDRList(cidrs string) []string {
+	var out []string
+	start := 0
+	for i := 0; i <= len(cidrs); i++ {
+		if i == len(cidrs) || cidrs[i] == ',' {
+			if i > start {
+				out = append(out, cidrs[start:i])
+			}
+			start = i + 1
+		}
+	}
+	return out
+}
+
+func isIPv6CIDR(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	return ip.To4() == nil
+}
+
+// familyScope is the peer set resolved for one IP family's ingress or
+// egress rule. ok is false when a restrictive policy mode failed to
+// resolve any peers for an active family (e.g. no clusterLister, no
+// matching node addresses); generatePodNetworkPolicy then omits that rule
+// entirely (deny) instead of building it with an empty peer list, which
+// Kubernetes treats as "matches everything".
+type familyScope struct {
+	peers []knetworkingv1.NetworkPolicyPeer
+	ok    bool
+}
+
+// resolveFamilyScope builds the familyScope for one IP family. active is
+// false when the pod doesn't use that family at all, in which case the
+// scope is simply unused by the caller. restrictive is true when the
+// current mode is expected to produce a non-empty peer list (any mode but
+// allow-all for ingress; egressEnabled for egress); if it resolved zero
+// peers anyway, that's a failure to fail closed on, not a legitimate
+// "no restriction" answer, so it's logged loudly.
+func resolveFamilyScope(active, restrictive bool, peers []knetworkingv1.NetworkPolicyPeer, pod *corev1.Pod, label string) familyScope {
+	if !active {
+		return familyScope{}
+	}
+	if restrictive && len(peers) == 0 {
+		logrus.Warnf("networkpolicy: hostPortsUpdateHandler: pod=%s/%s resolved zero %s peers; denying instead of failing open", pod.Namespace, pod.Name, label)
+		return familyScope{ok: false}
+	}
+	return familyScope{peers: peers, ok: true}
+}
+
+// generatePodNetworkPolicies builds one NetworkPolicy per IP family pod
+// needs host ports firewalled for. v4Ingress/v6Ingress scope ingress; when
+// egressEnabled is true, v4Egress/v6Egress scope egress too (they are
+// intentionally not just the ingress peers - see resolveEgressPeers). When
+// egressEnabled is false the policy carries no egress rule at all, leaving
+// egress unrestricted as before peer scoping existed.
+func generatePodNetworkPolicies(pod *corev1.Pod, ipv4, ipv6 bool, v4Ingress, v6Ingress, v4Egress, v6Egress familyScope, egressEnabled bool) []*knetworkingv1.NetworkPolicy {
+	if ipv4 && ipv6 {
+		return []*knetworkingv1.NetworkPolicy{
+			generatePodNetworkPolicy(pod, ipv4Suffix, v4Ingress, v4Egress, egressEnabled),
+			generatePodNetworkPolicy(pod, ipv6Suffix, v6Ingress, v6Egress, egressEnabled),
+		}
+	}
+	if ipv6 {
+		return []*knetworkingv1.NetworkPolicy{
+			generatePodNetworkPolicy(pod, "", v6Ingress, v6Egress, egressEnabled),
+		}
+	}
+	// default to IPv4, matching the historical single-stack behavior
+	return []*knetworkingv1.NetworkPolicy{
+		generatePodNetworkPolicy(pod, "", v4Ingress, v4Egress, egressEnabled),
+	}
+}
+
+func ipBlockPeer(cidr string, except []string) knetworkingv1.NetworkPolicyPeer {
+	return knetworkingv1.NetworkPolicyPeer{
+		IPBlock: &knetworkingv1.IPBlock{
+			CIDR:   cidr,
+			Except: except,
+		},
+	}
+}
+
+func generatePodNetworkPolicy(pod *corev1.Pod, nameSuffix string, ingress, egress familyScope, egressEnabled bool) *knetworkingv1.NetworkPolicy {
+	policyTypes := []knetworkingv1.PolicyType{knetworkingv1.PolicyTypeIngress}
+	var ingressRules []knetworkingv1.NetworkPolicyIngressRule
+	if ingress.ok {
+		ingressRules = []knetworkingv1.NetworkPolicyIngressRule{
+			{
+				From:  ingress.peers,
+				Ports: []knetworkingv1.NetworkPolicyPort{},
+			},
+		}
+	}
+	// else: leave ingressRules nil. PolicyTypes still declares Ingress, so
+	// zero rules denies all ingress - the fail-closed counterpart to an
+	// empty From, which Kubernetes instead treats as "allow from anywhere".
+
+	var egressRules []knetworkingv1.NetworkPolicyEgressRule
+	if egressEnabled {
+		policyTypes = append(policyTypes, knetworkingv1.PolicyTypeEgress)
+		if egress.ok {
+			egressRules = []knetworkingv1.NetworkPolicyEgressRule{
+				{
+					To: egress.peers,
+				},
+			}
+		}
+		// else: same fail-closed reasoning as ingress, applied to egress.
+	}
+
 	np := &knetworkingv1.NetworkPolicy{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "hp-" + pod.Name,
+			Name:      "hp-" + pod.Name + nameSuffix,
 			Namespace: pod.Namespace,
 			OwnerReferences: []metav1.OwnerReference{
 				{
@@ -117,12 +363,9 @@ func generatePodNetworkPolicy(pod *corev1.Pod) *knetworkingv1.NetworkPolicy {
 			PodSelector: metav1.LabelSelector{
 				MatchLabels: map[string]string{PodNameFieldLabel: pod.Name},
 			},
-			Ingress: []knetworkingv1.NetworkPolicyIngressRule{
-				{
-					From:  []knetworkingv1.NetworkPolicyPeer{},
-					Ports: []knetworkingv1.NetworkPolicyPort{},
-				},
-			},
+			PolicyTypes: policyTypes,
+			Ingress:     ingressRules,
+			Egress:      egressRules,
 		},
 	}
 	return np
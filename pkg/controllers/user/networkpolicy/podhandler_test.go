@@ -0,0 +1,139 @@
+package networkpolicy
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	knetworkingv1 "k8s.io/api/networking/v1"
+)
+
+func podWithIPs(ips ...string) *corev1.Pod {
+	pod := &corev1.Pod{}
+	pod.Name = "test-pod"
+	for _, ip := range ips {
+		pod.Status.PodIPs = append(pod.Status.PodIPs, corev1.PodIP{IP: ip})
+	}
+	if len(ips) > 0 {
+		pod.Status.PodIP = ips[0]
+	}
+	return pod
+}
+
+func TestGeneratePodNetworkPoliciesIPv4(t *testing.T) {
+	pod := podWithIPs("10.42.0.12")
+	ipv4, ipv6 := podIPFamilies(pod)
+	if !ipv4 || ipv6 {
+		t.Fatalf("expected ipv4-only pod, got ipv4=%v ipv6=%v", ipv4, ipv6)
+	}
+
+	v4Peers := []knetworkingv1.NetworkPolicyPeer{ipBlockPeer(ipv4Any, nil)}
+	v4Ingress := familyScope{peers: v4Peers, ok: true}
+	nps := generatePodNetworkPolicies(pod, ipv4, ipv6, v4Ingress, familyScope{}, familyScope{}, familyScope{}, false)
+	if len(nps) != 1 {
+		t.Fatalf("expected a single policy for single-stack v4, got %d", len(nps))
+	}
+	if nps[0].Name != "hp-test-pod" {
+		t.Errorf("unexpected policy name %q", nps[0].Name)
+	}
+	assertPeerCIDR(t, nps[0], ipv4Any)
+}
+
+func TestGeneratePodNetworkPoliciesIPv6(t *testing.T) {
+	pod := podWithIPs("2001:db8::1")
+	ipv4, ipv6 := podIPFamilies(pod)
+	if ipv4 || !ipv6 {
+		t.Fatalf("expected ipv6-only pod, got ipv4=%v ipv6=%v", ipv4, ipv6)
+	}
+
+	v6Peers := []knetworkingv1.NetworkPolicyPeer{ipBlockPeer(ipv6Any, nil)}
+	v6Ingress := familyScope{peers: v6Peers, ok: true}
+	nps := generatePodNetworkPolicies(pod, ipv4, ipv6, familyScope{}, v6Ingress, familyScope{}, familyScope{}, false)
+	if len(nps) != 1 {
+		t.Fatalf("expected a single policy for single-stack v6, got %d", len(nps))
+	}
+	if nps[0].Name != "hp-test-pod" {
+		t.Errorf("unexpected policy name %q", nps[0].Name)
+	}
+	assertPeerCIDR(t, nps[0], ipv6Any)
+}
+
+func TestGeneratePodNetworkPoliciesDualStack(t *testing.T) {
+	pod := podWithIPs("10.42.0.12", "2001:db8::1")
+	ipv4, ipv6 := podIPFamilies(pod)
+	if !ipv4 || !ipv6 {
+		t.Fatalf("expected dual-stack pod, got ipv4=%v ipv6=%v", ipv4, ipv6)
+	}
+
+	v4Peers := []knetworkingv1.NetworkPolicyPeer{ipBlockPeer(ipv4Any, []string{"10.42.0.0/16"})}
+	v6Peers := []knetworkingv1.NetworkPolicyPeer{ipBlockPeer(ipv6Any, []string{"2001:db8::/32"})}
+	v4Ingress := familyScope{peers: v4Peers, ok: true}
+	v6Ingress := familyScope{peers: v6Peers, ok: true}
+	nps := generatePodNetworkPolicies(pod, ipv4, ipv6, v4Ingress, v6Ingress, familyScope{}, familyScope{}, false)
+	if len(nps) != 2 {
+		t.Fatalf("expected two policies for dual-stack, got %d", len(nps))
+	}
+	if nps[0].Name != "hp-test-pod-v4" || nps[1].Name != "hp-test-pod-v6" {
+		t.Errorf("unexpected policy names %q, %q", nps[0].Name, nps[1].Name)
+	}
+	assertPeerCIDR(t, nps[0], ipv4Any)
+	assertPeerCIDR(t, nps[1], ipv6Any)
+	if len(nps[0].Spec.Ingress[0].From[0].IPBlock.Except) != 1 {
+		t.Errorf("expected v4 except entries to be threaded through")
+	}
+}
+
+func TestGeneratePodNetworkPolicyDeniesIngressWhenScopeNotOK(t *testing.T) {
+	pod := podWithIPs("10.42.0.12")
+
+	np := generatePodNetworkPolicy(pod, "", familyScope{ok: false}, familyScope{}, false)
+	if len(np.Spec.Ingress) != 0 {
+		t.Fatalf("expected no ingress rule when the family scope failed to resolve, got %+v", np.Spec.Ingress)
+	}
+}
+
+func TestGeneratePodNetworkPolicyDeniesEgressWhenScopeNotOK(t *testing.T) {
+	pod := podWithIPs("10.42.0.12")
+
+	np := generatePodNetworkPolicy(pod, "", familyScope{peers: []knetworkingv1.NetworkPolicyPeer{ipBlockPeer(ipv4Any, nil)}, ok: true}, familyScope{ok: false}, true)
+	if len(np.Spec.Egress) != 0 {
+		t.Fatalf("expected no egress rule when the family scope failed to resolve, got %+v", np.Spec.Egress)
+	}
+	found := false
+	for _, pt := range np.Spec.PolicyTypes {
+		if pt == knetworkingv1.PolicyTypeEgress {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected PolicyTypeEgress to still be declared even though egress is denied, got %+v", np.Spec.PolicyTypes)
+	}
+}
+
+// TestHostPortsUsesNumericContainerPortDirectly documents that hostPorts
+// does not resolve named container ports: a real API server always
+// populates ContainerPort numerically even when Name is also set (Name is
+// how Services/probes reference the port, not how the container itself
+// reports it), so there is nothing to resolve. This is a deliberate
+// non-feature, not missing coverage.
+func TestHostPortsUsesNumericContainerPortDirectly(t *testing.T) {
+	pod := podWithIPs("10.42.0.12")
+	pod.Spec.Containers = []corev1.Container{
+		{Ports: []corev1.ContainerPort{{Name: "http", HostPort: 8080, ContainerPort: 8080, Protocol: corev1.ProtocolTCP}}},
+	}
+
+	ports, hasHostPorts := hostPorts(pod)
+	if !hasHostPorts || len(ports) != 1 {
+		t.Fatalf("expected a single hostPort, got %d (hasHostPorts=%v)", len(ports), hasHostPorts)
+	}
+	if ports[0].Port.IntValue() != 8080 {
+		t.Errorf("expected the numeric ContainerPort to be used as-is, got %+v", ports[0].Port)
+	}
+}
+
+func assertPeerCIDR(t *testing.T, np *knetworkingv1.NetworkPolicy, cidr string) {
+	t.Helper()
+	from := np.Spec.Ingress[0].From
+	if len(from) != 1 || from[0].IPBlock == nil || from[0].IPBlock.CIDR != cidr {
+		t.Fatalf("expected a single IPBlock peer for %s, got %+v", cidr, from)
+	}
+}
@@ -0,0 +1,155 @@
+package networkpolicy
+
+import (
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	knetworkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	// HostPortPolicyAnnotation, set on a namespace, controls how permissive
+	// the hp-<podname> NetworkPolicies generated for pods in that namespace
+	// are. Only the namespace's own annotation is read; an annotation on the
+	// namespace's project is not consulted.
+	HostPortPolicyAnnotation = "field.cattle.io/hostPortPolicy"
+
+	// HostPortPolicyAllowAll is the historical behavior: any source/destination
+	// may reach the hostPort, modulo the cluster's own pod/service CIDRs.
+	HostPortPolicyAllowAll = "allow-all"
+	// HostPortPolicyClusterOnly restricts ingress/egress peers to the
+	// cluster's own pod and service CIDRs.
+	HostPortPolicyClusterOnly = "cluster-only"
+	// HostPortPolicyNodesOnly restricts ingress peers to the IPs of the
+	// node(s) actually running the pod; its egress peers additionally
+	// include the cluster's pod and service CIDRs (see resolveEgressPeers).
+	HostPortPolicyNodesOnly = "nodes-only"
+)
+
+// hostPortPolicyMode returns the HostPortPolicyAnnotation value configured
+// for namespace, defaulting to HostPortPolicyAllowAll when unset so existing
+// clusters see no behavior change.
+func (ph *podHandler) hostPortPolicyMode(namespace string) string {
+	if ph.nsLister == nil {
+		return HostPortPolicyAllowAll
+	}
+	ns, err := ph.nsLister.Get("", namespace)
+	if err != nil || ns == nil {
+		return HostPortPolicyAllowAll
+	}
+	if mode, ok := ns.Annotations[HostPortPolicyAnnotation]; ok && mode != "" {
+		return mode
+	}
+	return HostPortPolicyAllowAll
+}
+
+// resolvePeers computes the ingress/egress peer scoping to use for pod's
+// IPv4 and/or IPv6 hp-<podname> policies, according to mode.
+func (ph *podHandler) resolvePeers(pod *corev1.Pod, mode string, ipv4, ipv6 bool, v4ClusterCIDRs, v6ClusterCIDRs []string) (v4Peers, v6Peers []knetworkingv1.NetworkPolicyPeer, err error) {
+	switch mode {
+	case HostPortPolicyClusterOnly:
+		if ipv4 {
+			v4Peers = cidrPeers(v4ClusterCIDRs)
+		}
+		if ipv6 {
+			v6Peers = cidrPeers(v6ClusterCIDRs)
+		}
+		return v4Peers, v6Peers, nil
+
+	case HostPortPolicyNodesOnly:
+		v4IPs, v6IPs, err := ph.nodeIPsForPod(pod)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ipv4 {
+			v4Peers = cidrPeers(hostCIDRs(v4IPs))
+		}
+		if ipv6 {
+			v6Peers = cidrPeers(hostCIDRs(v6IPs))
+		}
+		return v4Peers, v6Peers, nil
+
+	default: // HostPortPolicyAllowAll
+		if ipv4 {
+			v4Peers = []knetworkingv1.NetworkPolicyPeer{ipBlockPeer(ipv4Any, v4ClusterCIDRs)}
+		}
+		if ipv6 {
+			v6Peers = []knetworkingv1.NetworkPolicyPeer{ipBlockPeer(ipv6Any, v6ClusterCIDRs)}
+		}
+		return v4Peers, v6Peers, nil
+	}
+}
+
+// resolveEgressPeers computes the egress peer scoping for mode. It
+// deliberately does not just return the ingress peers:
+//   - allow-all must stay fully open on egress (that was the behavior
+//     before peer scoping existed - hostPort pods had no egress rule at
+//     all), so it reports egressEnabled=false and no Egress rule is added.
+//   - nodes-only's ingress peers are bare node /32s, which would also cut
+//     the pod off from cluster-internal services like kube-dns; its
+//     egress additionally allows the cluster's own pod/service CIDRs.
+//   - cluster-only's ingress peers already are the cluster CIDRs, so they
+//     double as the egress peers unchanged.
+func (ph *podHandler) resolveEgressPeers(mode string, v4IngressPeers, v6IngressPeers []knetworkingv1.NetworkPolicyPeer, v4ClusterCIDRs, v6ClusterCIDRs []string) (v4Peers, v6Peers []knetworkingv1.NetworkPolicyPeer, enabled bool) {
+	switch mode {
+	case HostPortPolicyAllowAll:
+		return nil, nil, false
+	case HostPortPolicyNodesOnly:
+		v4Peers = append(append([]knetworkingv1.NetworkPolicyPeer{}, v4IngressPeers...), cidrPeers(v4ClusterCIDRs)...)
+		v6Peers = append(append([]knetworkingv1.NetworkPolicyPeer{}, v6IngressPeers...), cidrPeers(v6ClusterCIDRs)...)
+		return v4Peers, v6Peers, true
+	default: // HostPortPolicyClusterOnly
+		return v4IngressPeers, v6IngressPeers, true
+	}
+}
+
+// nodeIPsForPod returns the IPv4 and IPv6 addresses of the node pod is
+// scheduled to, used by the nodes-only policy mode.
+func (ph *podHandler) nodeIPsForPod(pod *corev1.Pod) (v4IPs, v6IPs []string, err error) {
+	if ph.nodeLister == nil || pod.Spec.NodeName == "" {
+		return nil, nil, nil
+	}
+	node, err := ph.nodeLister.Get("", pod.Spec.NodeName)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type != corev1.NodeInternalIP && addr.Type != corev1.NodeExternalIP {
+			continue
+		}
+		ip := net.ParseIP(addr.Address)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			v4IPs = append(v4IPs, addr.Address)
+		} else {
+			v6IPs = append(v6IPs, addr.Address)
+		}
+	}
+	return v4IPs, v6IPs, nil
+}
+
+func cidrPeers(cidrs []string) []knetworkingv1.NetworkPolicyPeer {
+	peers := make([]knetworkingv1.NetworkPolicyPeer, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		peers = append(peers, knetworkingv1.NetworkPolicyPeer{
+			IPBlock: &knetworkingv1.IPBlock{CIDR: cidr},
+		})
+	}
+	return peers
+}
+
+// hostCIDRs turns bare node IPs into host (/32 or /128) CIDRs suitable for
+// an IPBlock peer.
+func hostCIDRs(ips []string) []string {
+	cidrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if net.ParseIP(ip).To4() != nil {
+			cidrs = append(cidrs, ip+"/32")
+		} else {
+			cidrs = append(cidrs, ip+"/128")
+		}
+	}
+	return cidrs
+}
@@ -0,0 +1,94 @@
+package networkpolicy
+
+import (
+	"testing"
+
+	knetworkingv1 "k8s.io/api/networking/v1"
+)
+
+func TestResolvePeersAllowAll(t *testing.T) {
+	ph := &podHandler{}
+	pod := podWithIPs("10.42.0.12")
+
+	v4Peers, v6Peers, err := ph.resolvePeers(pod, HostPortPolicyAllowAll, true, false, []string{"10.42.0.0/16"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(v4Peers) != 1 || v4Peers[0].IPBlock.CIDR != ipv4Any || len(v4Peers[0].IPBlock.Except) != 1 {
+		t.Fatalf("expected allow-all v4 peer with cluster CIDR excepted, got %+v", v4Peers)
+	}
+	if v6Peers != nil {
+		t.Fatalf("expected no v6 peers for an ipv4-only pod, got %+v", v6Peers)
+	}
+}
+
+func TestResolvePeersClusterOnly(t *testing.T) {
+	ph := &podHandler{}
+	pod := podWithIPs("10.42.0.12")
+
+	v4Peers, _, err := ph.resolvePeers(pod, HostPortPolicyClusterOnly, true, false, []string{"10.42.0.0/16", "10.43.0.0/16"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(v4Peers) != 2 {
+		t.Fatalf("expected one peer per cluster CIDR, got %+v", v4Peers)
+	}
+	for _, p := range v4Peers {
+		if p.IPBlock == nil || len(p.IPBlock.Except) != 0 {
+			t.Errorf("cluster-only peers should be plain CIDRs with no except, got %+v", p)
+		}
+	}
+}
+
+func TestResolvePeersNodesOnlyWithoutNodeLister(t *testing.T) {
+	ph := &podHandler{}
+	pod := podWithIPs("10.42.0.12")
+	pod.Spec.NodeName = "node1"
+
+	v4Peers, _, err := ph.resolvePeers(pod, HostPortPolicyNodesOnly, true, false, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(v4Peers) != 0 {
+		t.Fatalf("expected no peers when nodeLister is unset, got %+v", v4Peers)
+	}
+}
+
+func TestResolveEgressPeersAllowAllIsUnrestricted(t *testing.T) {
+	ph := &podHandler{}
+	ingress := []knetworkingv1.NetworkPolicyPeer{ipBlockPeer(ipv4Any, []string{"10.42.0.0/16"})}
+
+	v4Peers, v6Peers, enabled := ph.resolveEgressPeers(HostPortPolicyAllowAll, ingress, nil, []string{"10.42.0.0/16"}, nil)
+	if enabled {
+		t.Fatalf("expected allow-all to leave egress unrestricted, got enabled=true peers=%+v", v4Peers)
+	}
+	if v4Peers != nil || v6Peers != nil {
+		t.Fatalf("expected no egress peers for allow-all, got v4=%+v v6=%+v", v4Peers, v6Peers)
+	}
+}
+
+func TestResolveEgressPeersNodesOnlyAlsoAllowsClusterCIDRs(t *testing.T) {
+	ph := &podHandler{}
+	ingress := cidrPeers([]string{"10.0.0.5/32"})
+
+	v4Peers, _, enabled := ph.resolveEgressPeers(HostPortPolicyNodesOnly, ingress, nil, []string{"10.42.0.0/16", "10.43.0.0/16"}, nil)
+	if !enabled {
+		t.Fatalf("expected nodes-only to restrict egress")
+	}
+	if len(v4Peers) != 3 {
+		t.Fatalf("expected node peer plus both cluster CIDRs, got %+v", v4Peers)
+	}
+}
+
+func TestResolveEgressPeersClusterOnlyMatchesIngress(t *testing.T) {
+	ph := &podHandler{}
+	ingress := cidrPeers([]string{"10.42.0.0/16", "10.43.0.0/16"})
+
+	v4Peers, _, enabled := ph.resolveEgressPeers(HostPortPolicyClusterOnly, ingress, nil, nil, nil)
+	if !enabled {
+		t.Fatalf("expected cluster-only to restrict egress")
+	}
+	if len(v4Peers) != len(ingress) {
+		t.Fatalf("expected cluster-only egress peers to match ingress peers, got %+v", v4Peers)
+	}
+}
@@ -0,0 +1,208 @@
+package networkpolicy
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "github.com/rancher/types/apis/core/v1"
+	networkingv1 "github.com/rancher/types/apis/networking.k8s.io/v1"
+	"github.com/sirupsen/logrus"
+	knetworkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DefaultHostPortNetpolResync is the interval used when --hostport-netpol-resync
+// isn't set.
+const DefaultHostPortNetpolResync = 5 * time.Minute
+
+var (
+	netpolReconciledTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "rancher",
+		Subsystem: "hostport_netpol",
+		Name:      "reconciled_total",
+		Help:      "Total hp-<podname> NetworkPolicies examined by the reconciler.",
+	})
+	netpolDeletedOrphanTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "rancher",
+		Subsystem: "hostport_netpol",
+		Name:      "deleted_orphan_total",
+		Help:      "hp-<podname> NetworkPolicies deleted because their owner pod no longer exists, no longer has hostPorts, or no longer matches the policy's IP family.",
+	})
+	netpolDriftRepairedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "rancher",
+		Subsystem: "hostport_netpol",
+		Name:      "drift_repaired_total",
+		Help:      "hp-<podname> NetworkPolicies whose programmed ports no longer matched the pod's hostPorts and were repaired.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(netpolReconciledTotal, netpolDeletedOrphanTotal, netpolDriftRepairedTotal)
+}
+
+// reconciler periodically lists every hp-<podname> NetworkPolicy across all
+// namespaces and converges it against the live pod it was generated from:
+// deleting it if the pod is gone (or no longer has hostPorts), and
+// repairing its Ports if the pod's hostPort set has drifted, e.g. because a
+// container was added/removed/reordered.
+type reconciler struct {
+	npmgr     *netpolMgr
+	npClient  networkingv1.NetworkPolicyInterface
+	podLister v1.PodLister
+	interval  time.Duration
+}
+
+func newReconciler(npmgr *netpolMgr, npClient networkingv1.NetworkPolicyInterface, podLister v1.PodLister, interval time.Duration) *reconciler {
+	if interval <= 0 {
+		interval = DefaultHostPortNetpolResync
+	}
+	return &reconciler{npmgr: npmgr, npClient: npClient, podLister: podLister, interval: interval}
+}
+
+// run blocks, reconciling every r.interval until stopc is closed.
+func (r *reconciler) run(stopc <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopc:
+			return
+		case <-ticker.C:
+			if err := r.reconcileOnce(); err != nil {
+				logrus.Errorf("networkpolicy: reconciler: reconcile pass failed: %v", err)
+			}
+		}
+	}
+}
+
+func (r *reconciler) reconcileOnce() error {
+	nps, err := r.npmgr.npLister.List("", labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	for _, np := range nps {
+		if !strings.HasPrefix(np.Name, "hp-") {
+			continue
+		}
+		podName, podUID, ok := ownerPodRef(np)
+		if !ok {
+			// Not a policy this controller created (no Pod owner
+			// reference we recognize) - leave it alone. Naming a pod
+			// "web-v4" and getting a coincidental "hp-web-v4" policy
+			// from someone else must never cause us to touch it.
+			continue
+		}
+		netpolReconciledTotal.Inc()
+
+		pod, err := r.podLister.Get(np.Namespace, podName)
+		if err != nil || pod == nil || pod.DeletionTimestamp != nil || string(pod.UID) != podUID {
+			r.deleteOrphan(np, "owner pod no longer exists")
+			continue
+		}
+
+		expectedPorts, hasHostPorts := hostPorts(pod)
+		if !hasHostPorts {
+			r.deleteOrphan(np, "pod no longer declares any hostPorts")
+			continue
+		}
+
+		if ipv4, ipv6 := podIPFamilies(pod); ipv4 || ipv6 {
+			suffix := strings.TrimPrefix(np.Name, "hp-"+podName)
+			if !suffixMatchesFamilies(suffix, ipv4, ipv6) {
+				r.deleteOrphan(np, "policy's IP family no longer matches the pod's observed PodIPs")
+				continue
+			}
+		}
+
+		sort.Slice(expectedPorts, func(i, j int) bool {
+			return portToString(expectedPorts[i]) < portToString(expectedPorts[j])
+		})
+
+		if len(np.Spec.Ingress) == 0 {
+			logrus.Warnf("networkpolicy: reconciler: %s/%s is owned by pod %s but has no ingress rule to repair; skipping", np.Namespace, np.Name, podName)
+			continue
+		}
+		if portsEqual(np.Spec.Ingress[0].Ports, expectedPorts) {
+			continue
+		}
+
+		logrus.Infof("networkpolicy: reconciler: repairing drifted ports on %s/%s", np.Namespace, np.Name)
+		updated := np.DeepCopy()
+		updated.Spec.Ingress[0].Ports = expectedPorts
+		if _, err := r.npClient.Update(updated); err != nil {
+			logrus.Errorf("networkpolicy: reconciler: failed to repair %s/%s: %v", np.Namespace, np.Name, err)
+			continue
+		}
+		netpolDriftRepairedTotal.Inc()
+		r.npmgr.auditProgram(AuditDispositionReconciled, updated, auditContextFromPod(pod), nil)
+	}
+	return nil
+}
+
+func (r *reconciler) deleteOrphan(np *knetworkingv1.NetworkPolicy, reason string) {
+	logrus.Infof("networkpolicy: reconciler: deleting orphaned policy %s/%s: %s", np.Namespace, np.Name, reason)
+	if err := r.npClient.DeleteNamespaced(np.Namespace, np.Name, nil); err != nil {
+		logrus.Errorf("networkpolicy: reconciler: failed to delete orphaned policy %s/%s: %v", np.Namespace, np.Name, err)
+		return
+	}
+	netpolDeletedOrphanTotal.Inc()
+}
+
+// ownerPodRef returns the name and UID of np's owning pod, as recorded in
+// its OwnerReferences by generatePodNetworkPolicy. Trying to recover the pod
+// name by stripping the hp- prefix and -v4/-v6 suffix from np.Name is
+// ambiguous (a single-stack pod literally named "web-v4" produces a policy
+// "hp-web-v4" indistinguishable from the dual-stack IPv4 policy for a pod
+// named "web"), so ownership is only ever read from the OwnerReference.
+func ownerPodRef(np *knetworkingv1.NetworkPolicy) (podName, podUID string, ok bool) {
+	for _, ref := range np.OwnerReferences {
+		if ref.Kind == "Pod" {
+			return ref.Name, string(ref.UID), true
+		}
+	}
+	return "", "", false
+}
+
+// suffixMatchesFamilies reports whether a hp-<podname><suffix> policy's
+// family suffix still corresponds to how generatePodNetworkPolicies would
+// name a policy for a pod reporting ipv4/ipv6. generatePodNetworkPolicies
+// only ever uses the -v4/-v6 suffixes for a dual-stack pod; a single-stack
+// pod (whichever family) always gets the unsuffixed name. Without this, a
+// pod that was dual-stack while still pending (getting both hp-<name>-v4
+// and hp-<name>-v6) and then reports as single-stack leaves one of those
+// two permanently orphaned: its owner pod is alive and still has
+// hostPorts, so nothing else in reconcileOnce would ever delete it.
+func suffixMatchesFamilies(suffix string, ipv4, ipv6 bool) bool {
+	dualStack := ipv4 && ipv6
+	switch suffix {
+	case ipv4Suffix, ipv6Suffix:
+		return dualStack
+	default:
+		return !dualStack
+	}
+}
+
+func portsEqual(a, b []knetworkingv1.NetworkPolicyPort) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sorted := make([]knetworkingv1.NetworkPolicyPort, len(a))
+	copy(sorted, a)
+	sort.Slice(sorted, func(i, j int) bool {
+		return portToString(sorted[i]) < portToString(sorted[j])
+	})
+	sortedB := make([]knetworkingv1.NetworkPolicyPort, len(b))
+	copy(sortedB, b)
+	sort.Slice(sortedB, func(i, j int) bool {
+		return portToString(sortedB[i]) < portToString(sortedB[j])
+	})
+	for i := range sorted {
+		if portToString(sorted[i]) != portToString(sortedB[i]) {
+			return false
+		}
+	}
+	return true
+}
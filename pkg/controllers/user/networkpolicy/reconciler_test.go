@@ -0,0 +1,77 @@
+package networkpolicy
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	knetworkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestOwnerPodRefReadsPodOwnerReference(t *testing.T) {
+	np := &knetworkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "hp-web-v4",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Pod", Name: "web-v4", UID: types.UID("abc-123")},
+			},
+		},
+	}
+	name, uid, ok := ownerPodRef(np)
+	if !ok || name != "web-v4" || uid != "abc-123" {
+		t.Fatalf("ownerPodRef() = %q, %q, %v; want web-v4, abc-123, true", name, uid, ok)
+	}
+}
+
+func TestOwnerPodRefIgnoresPoliciesWithoutAPodOwner(t *testing.T) {
+	np := &knetworkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "hp-web-v4"}}
+	if _, _, ok := ownerPodRef(np); ok {
+		t.Fatalf("expected ownerPodRef to report ok=false for a policy with no Pod owner reference")
+	}
+}
+
+func TestSuffixMatchesFamiliesStaleDualStackSuffixOnceSingleStack(t *testing.T) {
+	// pod was dual-stack while pending (hp-<name>-v4 and hp-<name>-v6 both
+	// programmed), then reported as IPv4-only: the -v6 policy is now stale,
+	// and -v4 is too, since a single-stack pod gets the unsuffixed name.
+	if suffixMatchesFamilies(ipv6Suffix, true, false) {
+		t.Errorf("expected -v6 suffix to be stale for a now-IPv4-only pod")
+	}
+	if suffixMatchesFamilies(ipv4Suffix, true, false) {
+		t.Errorf("expected -v4 suffix to be stale for a now-single-stack pod")
+	}
+	if !suffixMatchesFamilies("", true, false) {
+		t.Errorf("expected the unsuffixed name to match a single-stack pod")
+	}
+}
+
+func TestSuffixMatchesFamiliesStaleUnsuffixedOnceDualStack(t *testing.T) {
+	if suffixMatchesFamilies("", true, true) {
+		t.Errorf("expected the unsuffixed name to be stale once a pod becomes dual-stack")
+	}
+	if !suffixMatchesFamilies(ipv4Suffix, true, true) || !suffixMatchesFamilies(ipv6Suffix, true, true) {
+		t.Errorf("expected both -v4 and -v6 suffixes to match a dual-stack pod")
+	}
+}
+
+func TestPortsEqualIgnoresOrder(t *testing.T) {
+	pod := podWithIPs("10.42.0.12")
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "a", Ports: []corev1.ContainerPort{{HostPort: 8080, ContainerPort: 8080, Protocol: corev1.ProtocolTCP}}},
+		{Name: "b", Ports: []corev1.ContainerPort{{HostPort: 9090, ContainerPort: 9090, Protocol: corev1.ProtocolTCP}}},
+	}
+	ports, ok := hostPorts(pod)
+	if !ok || len(ports) != 2 {
+		t.Fatalf("expected two hostPorts, got %d (ok=%v)", len(ports), ok)
+	}
+
+	reversed := []knetworkingv1.NetworkPolicyPort{ports[1], ports[0]}
+	if !portsEqual(ports, reversed) {
+		t.Errorf("expected portsEqual to be order-independent")
+	}
+
+	if portsEqual(ports, reversed[:1]) {
+		t.Errorf("expected portsEqual to fail when lengths differ")
+	}
+}
@@ -0,0 +1,52 @@
+package networkpolicy
+
+import (
+	v1 "github.com/rancher/types/apis/core/v1"
+	managementv3 "github.com/rancher/types/apis/management.cattle.io/v3"
+	networkingv1 "github.com/rancher/types/apis/networking.k8s.io/v1"
+	"github.com/urfave/cli"
+)
+
+// Config bundles the listers/clients Register needs to wire up the
+// hostPort NetworkPolicy controller: the podHandler that programs
+// hp-<podname> NetworkPolicies, and the background reconciler that keeps
+// them converged with their owning pods.
+type Config struct {
+	Pods             v1.PodInterface
+	PodLister        v1.PodLister
+	NamespaceLister  v1.NamespaceLister
+	NodeLister       v1.NodeLister
+	ClusterLister    managementv3.ClusterLister
+	ClusterNamespace string
+	NpLister         networkingv1.NetworkPolicyLister
+	NpClient         networkingv1.NetworkPolicyInterface
+	Audit            AuditLogger
+}
+
+// NewPodHandler builds the podHandler that Sync's pods into hp-<podname>
+// NetworkPolicies, attaching cfg.Audit to its netpolMgr when set.
+func NewPodHandler(cfg Config) *podHandler {
+	var opts []NetpolMgrOption
+	if cfg.Audit != nil {
+		opts = append(opts, WithAuditLogger(cfg.Audit))
+	}
+	return &podHandler{
+		npmgr:            NewNetpolMgr(cfg.NpLister, cfg.NpClient, opts...),
+		pods:             cfg.Pods,
+		nsLister:         cfg.NamespaceLister,
+		nodeLister:       cfg.NodeLister,
+		clusterLister:    cfg.ClusterLister,
+		clusterNamespace: cfg.ClusterNamespace,
+	}
+}
+
+// Register builds the podHandler for cfg and starts its background
+// reconciler at the interval configured by --hostport-netpol-resync (see
+// flags.go), stopping when stopc is closed. This is the call site that
+// makes AuditLogger injection, the reconciler, and the resync flag
+// actually reachable, rather than unused helpers.
+func Register(ctx *cli.Context, cfg Config, stopc <-chan struct{}) *podHandler {
+	ph := NewPodHandler(cfg)
+	ph.npmgr.StartReconciler(cfg.PodLister, ResyncIntervalFromFlag(ctx), stopc)
+	return ph
+}